@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/daknob/eldim/internal/audit"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+auditChain is eldim's shared audit trail. It is nil when auditing is
+disabled
+*/
+var auditChain *audit.Chain
+
+/*
+configureAudit builds the audit trail described by conf.Audit
+*/
+func configureAudit() {
+	chain, err := conf.Audit.Chain()
+	if err != nil {
+		logrus.Fatalf("Failed to configure the audit trail: %v", err)
+	}
+	auditChain = chain
+}
+
+/*
+auditVerifyResponse is the JSON body returned by v1auditVerify
+*/
+type auditVerifyResponse struct {
+	Events   int    `json:"events"`
+	OK       bool   `json:"ok"`
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+/*
+v1auditVerify walks the audit trail's file sink in order and reports
+the sequence number of the first broken hash-chain link, if any
+*/
+func v1auditVerify(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if conf.Audit.File == "" {
+		http.Error(w, "Audit verification requires the audit file sink to be configured", http.StatusNotImplemented)
+		return
+	}
+
+	events, err := audit.ReadEvents(conf.Audit.File)
+	if err != nil {
+		logrus.Errorf("Failed to read the audit log for verification: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	verifyKey, err := conf.Audit.VerifyKey()
+	if err != nil {
+		logrus.Errorf("Failed to load the audit verification key: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := auditVerifyResponse{Events: len(events)}
+	brokenAt, err := audit.VerifyChain(events, verifyKey)
+	if err != nil {
+		resp.BrokenAt = brokenAt
+		resp.Error = err.Error()
+	} else {
+		resp.OK = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
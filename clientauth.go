@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/daknob/eldim/config"
+	"github.com/daknob/eldim/internal/auth"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+remoteIP extracts r's remote address without its port, falling back to
+the raw RemoteAddr if it isn't in host:port form
+*/
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+/*
+authenticateClient finds the configured client whose auth.Policy
+accepts r, trying each configured client in turn, and returns it. This
+is the upload-session handlers' equivalent of the client matching
+v1fileUpload (not part of this snapshot) is expected to perform
+*/
+func authenticateClient(r *http.Request) (*config.ClientConfig, error) {
+	req := auth.RequestFromHTTP(r, remoteIP(r))
+	req.Password = r.Header.Get("X-Eldim-Password")
+
+	for i := range clients {
+		policy, err := clients[i].Policy(r.Context())
+		if err != nil {
+			logrus.Warnf("Failed to build auth policy for client '%s': %v", clients[i].Name(), err)
+			continue
+		}
+		if policy.Verify(req) == nil {
+			return &clients[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no configured client accepts this request")
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/daknob/eldim/internal/audit"
+	"github.com/daknob/eldim/internal/secretref"
+)
+
+/*
+AuditConfig configures eldim's append-only audit trail
+*/
+type AuditConfig struct {
+	/* Enabled turns the audit trail on. When false, no audit events are
+	recorded */
+	Enabled bool `yaml:"enabled"`
+
+	/* File, if set, appends events as JSON lines to this path, rotated
+	once it exceeds FileMaxSizeBytes */
+	File             string `yaml:"file"`
+	FileMaxSizeBytes int64  `yaml:"filemaxsizebytes"`
+
+	/* Syslog, if true, also sends events to the local syslog daemon */
+	Syslog bool `yaml:"syslog"`
+
+	/* Webhook, if set, POSTs each event to this URL */
+	Webhook string `yaml:"webhook"`
+
+	/* SigningKeyHex, if set, is a hex-encoded Ed25519 private key used
+	to sign every event's hash, so tampering with a sink's history is
+	detectable even by a verifier that only has the public key */
+	SigningKeyHex string `yaml:"signingkeyhex"`
+
+	/* VerifyAuthUser/VerifyAuthPass gate the /api/v1/audit/verify
+	endpoint with HTTP Basic Auth, the same way PrometheusAuthUser/
+	PrometheusAuthPass gate /metrics */
+	VerifyAuthUser string                 `yaml:"verifyauthuser"`
+	VerifyAuthPass secretref.SecretString `yaml:"verifyauthpass"`
+}
+
+/*
+ResolveSecrets resolves the audit configuration's secret-reference
+fields, such as verifyauthpass
+*/
+func (a *AuditConfig) ResolveSecrets(ctx context.Context) error {
+	if err := a.VerifyAuthPass.Resolve(ctx); err != nil {
+		return fmt.Errorf("Failed to resolve verifyauthpass: %v", err)
+	}
+	return nil
+}
+
+/*
+Validate validates the audit configuration
+*/
+func (a *AuditConfig) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+
+	if a.File == "" && !a.Syslog && a.Webhook == "" {
+		return fmt.Errorf("audit is enabled but no sink (file, syslog, webhook) is configured")
+	}
+
+	if a.SigningKeyHex != "" {
+		key, err := hex.DecodeString(a.SigningKeyHex)
+		if err != nil {
+			return fmt.Errorf("signingkeyhex is not valid hex: %v", err)
+		}
+		if len(key) != ed25519.PrivateKeySize {
+			return fmt.Errorf("signingkeyhex must decode to %d bytes, got %d", ed25519.PrivateKeySize, len(key))
+		}
+	}
+
+	if a.VerifyAuthUser == "" || a.VerifyAuthPass.String() == "" {
+		return fmt.Errorf("audit is enabled, so verifyauthuser and verifyauthpass are required to gate /api/v1/audit/verify")
+	}
+
+	return nil
+}
+
+/*
+Chain builds the audit.Chain configured by AuditConfig, wiring up every
+enabled sink. It returns nil, nil if auditing is disabled
+*/
+func (a *AuditConfig) Chain() (*audit.Chain, error) {
+	if !a.Enabled {
+		return nil, nil
+	}
+
+	var sinks []audit.Sink
+
+	if a.File != "" {
+		sink, err := audit.NewFileSink(a.File, a.FileMaxSizeBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up file audit sink: %v", err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if a.Syslog {
+		sink, err := audit.NewSyslogSink()
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up syslog audit sink: %v", err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if a.Webhook != "" {
+		sinks = append(sinks, audit.NewWebhookSink(a.Webhook))
+	}
+
+	var signKey ed25519.PrivateKey
+	if a.SigningKeyHex != "" {
+		key, err := hex.DecodeString(a.SigningKeyHex)
+		if err != nil {
+			return nil, fmt.Errorf("signingkeyhex is not valid hex: %v", err)
+		}
+		signKey = ed25519.PrivateKey(key)
+	}
+
+	return audit.NewChain(signKey, sinks...), nil
+}
+
+/*
+VerifyKey returns the Ed25519 public key events were signed with, for
+use with audit.VerifyChain. It returns nil if no signing key is
+configured
+*/
+func (a *AuditConfig) VerifyKey() (ed25519.PublicKey, error) {
+	if a.SigningKeyHex == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(a.SigningKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("signingkeyhex is not valid hex: %v", err)
+	}
+
+	return ed25519.PrivateKey(key).Public().(ed25519.PublicKey), nil
+}
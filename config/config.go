@@ -8,6 +8,8 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"filippo.io/age"
 	"filippo.io/age/agessh"
@@ -16,6 +18,8 @@ import (
 
 	"github.com/daknob/eldim/internal/backend"
 
+	"github.com/daknob/eldim/internal/auth"
+	"github.com/daknob/eldim/internal/secretref"
 	"github.com/daknob/eldim/internal/swift"
 	"gopkg.in/yaml.v2"
 )
@@ -30,8 +34,9 @@ type Config struct {
 	MaxUploadRAM int64 `yaml:"maxuploadram"`
 
 	/* TLS Settings */
-	TLSChainPath string `yaml:"tlschain"`
-	TLSKeyPath   string `yaml:"tlskey"`
+	TLSChainPath string    `yaml:"tlschain"`
+	TLSKeyPath   string    `yaml:"tlskey"`
+	TLS          TLSConfig `yaml:"tls"`
 
 	/* Backend Server */
 	SwiftBackends []swift.BackendConfig `yaml:"swiftbackends"`
@@ -49,9 +54,78 @@ type Config struct {
 	} `yaml:"encryption"`
 
 	/* Prometheus Metrics */
-	PrometheusEnabled  bool   `yaml:"prometheusenabled"`
-	PrometheusAuthUser string `yaml:"prometheusauthuser"`
-	PrometheusAuthPass string `yaml:"prometheusauthpass"`
+	PrometheusEnabled  bool                   `yaml:"prometheusenabled"`
+	PrometheusAuthUser string                 `yaml:"prometheusauthuser"`
+	PrometheusAuthPass secretref.SecretString `yaml:"prometheusauthpass"`
+
+	/* Secret Resolution. When set, secret-reference fields
+	(prometheusauthpass, the audit verifyauthpass, and a client's
+	password) may use an "age:bundle.age#field" reference, decrypted
+	with the identity in this file. Backend credentials are not
+	resolved as secret references */
+	SecretAgeIdentityFile string `yaml:"secretageidentityfile"`
+
+	/* Replication */
+	Replication ReplicationConfig `yaml:"replication"`
+
+	/* Audit */
+	Audit AuditConfig `yaml:"audit"`
+}
+
+/*
+ReplicationConfig configures how an upload is fanned out across the
+configured backends
+*/
+type ReplicationConfig struct {
+	/* Policy selects which backends must accept an upload for it to be
+	considered successful, e.g. "all", "quorum:2",
+	"primary+async-replica", or "by-tag:offsite". Defaults to "all" */
+	Policy string `yaml:"policy"`
+
+	/* PerBackendTimeoutSeconds bounds how long a single backend's
+	upload may take. Defaults to 30 */
+	PerBackendTimeoutSeconds int `yaml:"perbackendtimeoutseconds"`
+
+	/* Retries is how many additional attempts are made against a
+	backend after its first attempt fails. Defaults to 2 */
+	Retries int `yaml:"retries"`
+
+	/* QueuePath is where the BoltDB reconciliation queue is kept, for
+	replicas that could not be written to a backend at upload time.
+	Reconciliation is disabled if empty */
+	QueuePath string `yaml:"queuepath"`
+}
+
+/*
+ResolveSecrets resolves every secret-reference field of the
+configuration, such as prometheusauthpass, in place. It must be called
+after the configuration file is unmarshalled and before Validate
+*/
+func (conf *Config) ResolveSecrets(ctx context.Context) error {
+	if conf.SecretAgeIdentityFile != "" {
+		f, err := os.Open(conf.SecretAgeIdentityFile)
+		if err != nil {
+			return fmt.Errorf("Failed to open secret age identity file: %v", err)
+		}
+		defer f.Close()
+
+		identities, err := age.ParseIdentities(f)
+		if err != nil {
+			return fmt.Errorf("Failed to parse secret age identity file: %v", err)
+		}
+
+		secretref.RegisterAgeResolver(secretref.NewAgeResolver(identities))
+	}
+
+	if err := conf.PrometheusAuthPass.Resolve(ctx); err != nil {
+		return fmt.Errorf("Failed to resolve prometheusauthpass: %v", err)
+	}
+
+	if err := conf.Audit.ResolveSecrets(ctx); err != nil {
+		return fmt.Errorf("Failed to resolve audit secrets: %v", err)
+	}
+
+	return nil
 }
 
 /*
@@ -67,30 +141,40 @@ func (conf *Config) Validate() error {
 		return fmt.Errorf("TCP Listening Port must be below 65535")
 	}
 
-	/* Validate TLS Chain File */
-	if conf.TLSChainPath == "" {
-		return fmt.Errorf("TLS Chain File is required. eldim works only with HTTPS")
-	}
-	f, err := os.Open(conf.TLSChainPath)
-	if err != nil {
-		return fmt.Errorf("Failed to open TLS Chain File: %v", err)
-	}
-	err = f.Close()
-	if err != nil {
-		return fmt.Errorf("Failed to close TLS Chain File: %v", err)
+	/* Validate TLS Settings */
+	var err error
+	if err = conf.TLS.Validate(); err != nil {
+		return fmt.Errorf("Invalid TLS configuration: %v", err)
 	}
 
-	/* Validate TLS Key File */
-	if conf.TLSKeyPath == "" {
-		return fmt.Errorf("TLS Key File is required. eldim works only with HTTPS")
-	}
-	f, err = os.Open(conf.TLSKeyPath)
-	if err != nil {
-		return fmt.Errorf("Failed to open TLS Key File: %v", err)
-	}
-	err = f.Close()
-	if err != nil {
-		return fmt.Errorf("Failed to close TLS Key File: %v", err)
+	/* TLSChainPath/TLSKeyPath are only required when ACME is not
+	configured to manage certificates instead */
+	if conf.TLS.ACME == nil {
+		/* Validate TLS Chain File */
+		if conf.TLSChainPath == "" {
+			return fmt.Errorf("TLS Chain File is required. eldim works only with HTTPS")
+		}
+		f, err := os.Open(conf.TLSChainPath)
+		if err != nil {
+			return fmt.Errorf("Failed to open TLS Chain File: %v", err)
+		}
+		err = f.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to close TLS Chain File: %v", err)
+		}
+
+		/* Validate TLS Key File */
+		if conf.TLSKeyPath == "" {
+			return fmt.Errorf("TLS Key File is required. eldim works only with HTTPS")
+		}
+		f, err = os.Open(conf.TLSKeyPath)
+		if err != nil {
+			return fmt.Errorf("Failed to open TLS Key File: %v", err)
+		}
+		err = f.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to close TLS Key File: %v", err)
+		}
 	}
 
 	/* Validate Backends */
@@ -118,6 +202,20 @@ func (conf *Config) Validate() error {
 		return fmt.Errorf("eldim needs at least one backend to operate, 0 found")
 	}
 
+	/* Validate Audit Settings */
+	if err = conf.Audit.Validate(); err != nil {
+		return fmt.Errorf("Invalid audit configuration: %v", err)
+	}
+
+	/* Validate the replication policy against the configured backends */
+	policy, err := backend.ParsePolicy(conf.ReplicationPolicy())
+	if err != nil {
+		return fmt.Errorf("Invalid replication policy: %v", err)
+	}
+	if _, _, err := policy.Select(conf.Clients()); err != nil {
+		return fmt.Errorf("Replication policy does not match the configured backends: %v", err)
+	}
+
 	/* Validate Max Upload RAM (in MB) */
 	if conf.MaxUploadRAM <= 0 {
 		return fmt.Errorf("Maximum Upload RAM must be a positive number")
@@ -142,6 +240,9 @@ func (conf *Config) Validate() error {
 			return fmt.Errorf("Failed to parse age ssh key Identity '%s': %v", r, err)
 		}
 	}
+	if _, err := conf.Recipients(); err != nil {
+		return fmt.Errorf("Failed to parse age recipients: %v", err)
+	}
 
 	/* Validate Prometheus Settings */
 	if conf.PrometheusEnabled == true {
@@ -152,10 +253,10 @@ func (conf *Config) Validate() error {
 		if !regexp.MustCompile("^[a-zA-Z0-9]{20,128}$").MatchString(conf.PrometheusAuthUser) {
 			return fmt.Errorf("The prometheusauthuser must contain a-z, A-Z, and 0-9, and must be 20-128 characters long")
 		}
-		if conf.PrometheusAuthPass == "" {
+		if conf.PrometheusAuthPass.String() == "" {
 			return fmt.Errorf("You need to set the prometheusauthpass in the configuration file. eldim only works with HTTP Basic Auth for Prometheus Metrics")
 		}
-		if !regexp.MustCompile("^[a-zA-Z0-9]{20,128}$").MatchString(conf.PrometheusAuthPass) {
+		if !regexp.MustCompile("^[a-zA-Z0-9]{20,128}$").MatchString(conf.PrometheusAuthPass.String()) {
 			return fmt.Errorf("The prometheusauthpass must contain a-z, A-Z, and 0-9, and must be 20-128 characters long")
 		}
 	}
@@ -187,11 +288,15 @@ func (conf *Config) Validate() error {
 		return fmt.Errorf("No clients have been supplied. eldim will not work")
 	}
 
-	/* Validate clients individually */
-	for i, c := range clients {
-		err = c.Validate()
+	/* Resolve and validate clients individually */
+	for i := range clients {
+		err = clients[i].ResolveSecrets(context.Background())
 		if err != nil {
-			return fmt.Errorf("Client '%s' (%d) is invalid: %v", c.Name(), i+1, err)
+			return fmt.Errorf("Client '%s' (%d) has an unresolvable secret: %v", clients[i].Name(), i+1, err)
+		}
+		err = clients[i].Validate()
+		if err != nil {
+			return fmt.Errorf("Client '%s' (%d) is invalid: %v", clients[i].Name(), i+1, err)
 		}
 	}
 
@@ -207,11 +312,11 @@ func (conf *Config) Validate() error {
 		nameSet[c.Name()] = true
 
 		/* Duplicate Password Check */
-		if c.Password != "" {
-			if passSet[c.Password] {
+		if c.Password.String() != "" {
+			if passSet[c.Password.String()] {
 				return fmt.Errorf("Client %d does not have a unique password: %s", i+1, c.Name())
 			}
-			passSet[c.Password] = true
+			passSet[c.Password.String()] = true
 		}
 
 		/* Duplicate IP Check */
@@ -263,15 +368,128 @@ func (conf *Config) Clients() []backend.Client {
 	return ret
 }
 
+/*
+Recipients parses and returns every configured age recipient, from both
+Encryption.AgeID (native X25519 recipients) and Encryption.AgeSSH (SSH
+public keys)
+*/
+func (conf *Config) Recipients() ([]age.Recipient, error) {
+	var recipients []age.Recipient
+
+	for _, r := range conf.Encryption.AgeID {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identity '%s': %v", r, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	for _, r := range conf.Encryption.AgeSSH {
+		recipient, err := agessh.ParseRecipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age ssh key identity '%s': %v", r, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
+
+/*
+ReplicationPolicy returns the configured replication policy expression,
+defaulting to "all" when none is set
+*/
+func (conf *Config) ReplicationPolicy() string {
+	if conf.Replication.Policy == "" {
+		return "all"
+	}
+	return conf.Replication.Policy
+}
+
+/*
+Replicator builds the backend.Replicator that fans uploads out to all
+configured backends according to the replication policy
+*/
+func (conf *Config) Replicator() (*backend.Replicator, error) {
+	policy, err := backend.ParsePolicy(conf.ReplicationPolicy())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid replication policy: %v", err)
+	}
+
+	clients := conf.Clients()
+	rep := backend.NewReplicator(clients, policy)
+
+	if conf.Replication.PerBackendTimeoutSeconds > 0 {
+		rep.PerBackendTimeout = time.Duration(conf.Replication.PerBackendTimeoutSeconds) * time.Second
+	}
+	if conf.Replication.Retries > 0 {
+		rep.Retries = conf.Replication.Retries
+	}
+
+	if conf.Replication.QueuePath != "" {
+		reconciler, err := backend.NewReconciler(conf.Replication.QueuePath, clients)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to set up replication reconciliation queue: %v", err)
+		}
+		rep.Reconciler = reconciler
+	}
+
+	return rep, nil
+}
+
 /*
 ClientConfig is the data structure containing all information about
 a client that can connect to the eldim service
 */
 type ClientConfig struct {
-	ClientName string   `yaml:"name"`
-	IPv4Addr   []string `yaml:"ipv4"`
-	IPv6Addr   []string `yaml:"ipv6"`
-	Password   string   `yaml:"password"`
+	ClientName string                 `yaml:"name"`
+	IPv4Addr   []string               `yaml:"ipv4"`
+	IPv6Addr   []string               `yaml:"ipv6"`
+	Password   secretref.SecretString `yaml:"password"`
+
+	/* Pluggable Authentication */
+	OIDC *auth.OIDCConfig `yaml:"oidc"`
+	JWT  *auth.JWTConfig  `yaml:"jwt"`
+	MTLS *auth.MTLSConfig `yaml:"mtls"`
+
+	/* AuthPolicy composes the enabled authentication methods into a
+	boolean expression, e.g. "mtls AND (oidc OR password)". Methods not
+	referenced in the policy are still validated, but never consulted.
+	If empty, eldim falls back to its legacy behavior: password OR
+	source IP */
+	AuthPolicy string `yaml:"authpolicy"`
+
+	/* oidcCache holds the client's lazily-discovered OIDC provider,
+	populated by ResolveSecrets. It is a pointer, rather than an
+	embedded sync.Once, so that ClientConfig itself stays an ordinary
+	copyable value */
+	oidcCache *oidcVerifierCache
+}
+
+/*
+oidcVerifierCache discovers a client's OIDC provider at most once and
+caches the result, so that Policy does not perform a network discovery
+round trip on every request
+*/
+type oidcVerifierCache struct {
+	once     sync.Once
+	verifier *auth.OIDCVerifier
+	err      error
+}
+
+/*
+ResolveSecrets resolves the client's secret-reference fields, such as
+its password. It must be called after the clients file is unmarshalled
+and before Validate
+*/
+func (client *ClientConfig) ResolveSecrets(ctx context.Context) error {
+	if err := client.Password.Resolve(ctx); err != nil {
+		return fmt.Errorf("Failed to resolve password: %v", err)
+	}
+	if client.OIDC != nil {
+		client.oidcCache = &oidcVerifierCache{}
+	}
+	return nil
 }
 
 /*
@@ -304,21 +522,119 @@ func (client *ClientConfig) Validate() error {
 	}
 
 	/* Ensure there is at least one of (password, IP) */
-	if client.Password == "" && len(client.IPv4()) == 0 && len(client.IPv6()) == 0 {
+	if client.Password.String() == "" && len(client.IPv4()) == 0 && len(client.IPv6()) == 0 {
 		return fmt.Errorf("Client does not have at least one of (password, IPv6, IPv4)")
 	}
 
 	/* Enforce client authentication password policy */
-	if len(client.Password) < 32 && client.Password != "" {
+	if len(client.Password.String()) < 32 && client.Password.String() != "" {
 		return fmt.Errorf("Client has a password shorter than 32 characters: 32-128 are acceptable")
 	}
-	if len(client.Password) > 128 {
+	if len(client.Password.String()) > 128 {
 		return fmt.Errorf("Client has a password longer than 128 characters: 32-128 are acceptable")
 	}
 
+	/* Validate pluggable authentication methods, if configured */
+	if client.OIDC != nil {
+		if err := client.OIDC.Validate(); err != nil {
+			return fmt.Errorf("OIDC configuration is invalid: %v", err)
+		}
+	}
+	if client.JWT != nil {
+		if err := client.JWT.Validate(); err != nil {
+			return fmt.Errorf("JWT configuration is invalid: %v", err)
+		}
+	}
+	if client.MTLS != nil {
+		if err := client.MTLS.Validate(); err != nil {
+			return fmt.Errorf("mTLS configuration is invalid: %v", err)
+		}
+	}
+
+	/* An AuthPolicy must only reference configured methods */
+	if client.AuthPolicy != "" {
+		if _, err := auth.ParsePolicy(client.AuthPolicy, client.verifiers()); err != nil {
+			return fmt.Errorf("Client has an invalid authpolicy: %v", err)
+		}
+	}
+
 	return nil
 }
 
+/*
+verifiers returns the set of auth.Verifier this client has configured,
+keyed by the name used in an AuthPolicy expression
+*/
+func (client *ClientConfig) verifiers() map[string]auth.Verifier {
+	verifiers := make(map[string]auth.Verifier)
+
+	if client.Password.String() != "" {
+		v := auth.NewPasswordVerifier(client.Password.String())
+		verifiers[v.Method()] = v
+	}
+	if len(client.IPv4()) > 0 || len(client.IPv6()) > 0 {
+		v := auth.NewIPVerifier(append(client.IPv6(), client.IPv4()...))
+		verifiers[v.Method()] = v
+	}
+	if client.MTLS != nil {
+		v := auth.NewMTLSVerifier(*client.MTLS)
+		verifiers[v.Method()] = v
+	}
+	if client.JWT != nil {
+		v := auth.NewJWTVerifier(*client.JWT)
+		verifiers[v.Method()] = v
+	}
+	if client.OIDC != nil {
+		/* OIDC requires a provider discovery round trip, which is
+		deferred to Policy() so that a single network failure during
+		config validation does not prevent eldim from starting. A
+		placeholder Verifier stands in so that validating an AuthPolicy
+		referencing "oidc" never has to wrap a nil Verifier */
+		v := auth.NewPendingOIDCVerifier()
+		verifiers[v.Method()] = v
+	}
+
+	return verifiers
+}
+
+/*
+Policy builds the composed auth.Policy for this client out of its
+configured authentication methods and AuthPolicy expression. If no
+AuthPolicy is configured, it falls back to eldim's legacy behavior of
+accepting either the password or an allowlisted source IP
+*/
+func (client *ClientConfig) Policy(ctx context.Context) (auth.Policy, error) {
+	verifiers := client.verifiers()
+
+	if client.OIDC != nil {
+		/* NewOIDCVerifier performs a full OIDC discovery round trip, so
+		it is only ever done once per client and cached, like JWKSCache
+		caches JWT signing keys, rather than on every call to Policy.
+		oidcCache is populated by ResolveSecrets, which must run before
+		Policy is ever called */
+		client.oidcCache.once.Do(func() {
+			client.oidcCache.verifier, client.oidcCache.err = auth.NewOIDCVerifier(ctx, *client.OIDC)
+		})
+		if client.oidcCache.err != nil {
+			return nil, fmt.Errorf("failed to set up OIDC verifier for client '%s': %v", client.Name(), client.oidcCache.err)
+		}
+		verifiers["oidc"] = client.oidcCache.verifier
+	}
+
+	if client.AuthPolicy != "" {
+		return auth.ParsePolicy(client.AuthPolicy, verifiers)
+	}
+
+	var legacy []auth.Verifier
+	if v, ok := verifiers["password"]; ok {
+		legacy = append(legacy, v)
+	}
+	if v, ok := verifiers["ip"]; ok {
+		legacy = append(legacy, v)
+	}
+	return auth.AnyOf(legacy...), nil
+}
+
 /*
 Name returns the name of the client, as configured
 */
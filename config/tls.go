@@ -0,0 +1,206 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+/*
+TLSConfig configures the TLS listener eldim serves on: the minimum
+protocol version, which cipher suites and curves are offered, an
+optional client CA for mutual TLS, and an optional ACME block for
+automatic certificate issuance
+*/
+type TLSConfig struct {
+	/* MinVersion is "1.2" or "1.3". Defaults to "1.2" */
+	MinVersion string `yaml:"min_version"`
+
+	/* CipherSuites is a list of cipher suite names, as returned by
+	tls.CipherSuites()[].Name. Defaults to Go's own secure default list */
+	CipherSuites []string `yaml:"cipher_suites"`
+
+	/* CurvePreferences is a list of curve names: "P256", "P384", "P521",
+	or "X25519". Defaults to P256 and X25519 */
+	CurvePreferences []string `yaml:"curve_preferences"`
+
+	/* ClientCA, if set, enables mutual TLS: eldim will request and
+	verify client certificates against this CA bundle. ClientConfig can
+	then match clients by certificate CN/SAN via its mtls block */
+	ClientCA string `yaml:"client_ca"`
+
+	/* ACME, if set, obtains and renews certificates automatically
+	instead of using TLSChainPath/TLSKeyPath */
+	ACME *ACMEConfig `yaml:"acme"`
+}
+
+/*
+ACMEConfig configures automatic certificate issuance via an ACME
+provider, using golang.org/x/crypto/acme/autocert
+*/
+type ACMEConfig struct {
+	/* CacheDir is where autocert persists issued certificates between
+	restarts */
+	CacheDir string `yaml:"cachedir"`
+
+	/* Hosts is the allowlist of hostnames autocert is willing to
+	request certificates for */
+	Hosts []string `yaml:"hosts"`
+}
+
+/*
+curveIDs maps the curve names accepted in curve_preferences to their
+crypto/tls identifiers
+*/
+var curveIDs = map[string]tls.CurveID{
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+	"X25519": tls.X25519,
+}
+
+/*
+cipherSuiteIDs maps every cipher suite name Go knows about to its
+identifier, built once from tls.CipherSuites()
+*/
+var cipherSuiteIDs = buildCipherSuiteIDs()
+
+func buildCipherSuiteIDs() map[string]uint16 {
+	ids := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		ids[cs.Name] = cs.ID
+	}
+	return ids
+}
+
+/*
+Validate validates the TLS configuration
+*/
+func (t *TLSConfig) Validate() error {
+	switch t.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("tls min_version must be '1.2' or '1.3', got '%s'", t.MinVersion)
+	}
+
+	for _, name := range t.CipherSuites {
+		if _, ok := cipherSuiteIDs[name]; !ok {
+			return fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+	}
+
+	for _, name := range t.CurvePreferences {
+		if _, ok := curveIDs[name]; !ok {
+			return fmt.Errorf("unknown curve '%s'", name)
+		}
+	}
+
+	if t.ClientCA != "" {
+		if _, err := ioutil.ReadFile(t.ClientCA); err != nil {
+			return fmt.Errorf("failed to read client_ca: %v", err)
+		}
+	}
+
+	if t.ACME != nil {
+		if t.ACME.CacheDir == "" {
+			return fmt.Errorf("acme requires a cachedir")
+		}
+		if len(t.ACME.Hosts) == 0 {
+			return fmt.Errorf("acme requires at least one host")
+		}
+	}
+
+	return nil
+}
+
+/*
+minVersion returns the crypto/tls minimum version constant for this
+configuration, defaulting to TLS 1.2
+*/
+func (t *TLSConfig) minVersion() uint16 {
+	if t.MinVersion == "1.3" {
+		return tls.VersionTLS13
+	}
+	return tls.VersionTLS12
+}
+
+/*
+cipherSuites returns the configured cipher suite IDs, or nil to let
+crypto/tls use its own secure default list
+*/
+func (t *TLSConfig) cipherSuites() []uint16 {
+	if len(t.CipherSuites) == 0 {
+		return nil
+	}
+	var ids []uint16
+	for _, name := range t.CipherSuites {
+		ids = append(ids, cipherSuiteIDs[name])
+	}
+	return ids
+}
+
+/*
+curvePreferences returns the configured curve IDs, defaulting to the
+same P256/X25519 pair eldim has always offered
+*/
+func (t *TLSConfig) curvePreferences() []tls.CurveID {
+	if len(t.CurvePreferences) == 0 {
+		return []tls.CurveID{tls.CurveP256, tls.X25519}
+	}
+	var ids []tls.CurveID
+	for _, name := range t.CurvePreferences {
+		ids = append(ids, curveIDs[name])
+	}
+	return ids
+}
+
+/*
+BuildTLSConfig builds the *tls.Config eldim's HTTP server should
+listen with, wiring in the minimum version, cipher suites, curve
+preferences, optional mTLS client CA, and optional ACME certificate
+management. chainPath/keyPath are used when ACME is not configured
+*/
+func (conf *Config) BuildTLSConfig(chainPath, keyPath string) (*tls.Config, error) {
+	t := conf.TLS
+
+	tlsConfig := &tls.Config{
+		PreferServerCipherSuites: true,
+		MinVersion:               t.minVersion(),
+		CipherSuites:             t.cipherSuites(),
+		CurvePreferences:         t.curvePreferences(),
+	}
+
+	if t.ClientCA != "" {
+		pem, err := ioutil.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse any certificate from client_ca")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if t.ACME != nil {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(t.ACME.CacheDir),
+			HostPolicy: autocert.HostWhitelist(t.ACME.Hosts...),
+		}
+		tlsConfig.GetCertificate = manager.GetCertificate
+		return tlsConfig, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(chainPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return tlsConfig, nil
+}
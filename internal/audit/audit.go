@@ -0,0 +1,176 @@
+/*
+Package audit implements eldim's append-only audit trail. Every upload
+attempt, successful or denied, is recorded as a structured Event and
+written to one or more Sinks. Each Event is hash-chained to the
+previous one and optionally Ed25519-signed, so that tampering with a
+sink's history after the fact is detectable, similar to Vault's audit
+backends.
+*/
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Event is a single structured audit record
+*/
+type Event struct {
+	/* Sequence is this event's position in the chain, starting at 1 */
+	Sequence uint64 `json:"sequence"`
+
+	Timestamp    time.Time `json:"timestamp"`
+	Client       string    `json:"client"`
+	SourceIP     string    `json:"source_ip"`
+	Object       string    `json:"object"`
+	Size         int64     `json:"size"`
+	Backends     []string  `json:"backends"`
+	AgeRecipient []string  `json:"age_recipients"`
+	Success      bool      `json:"success"`
+	Reason       string    `json:"reason,omitempty"`
+	RequestID    string    `json:"request_id"`
+
+	/* PrevHash is the Hash of the previous event in the chain, or 32
+	zero bytes for the first event */
+	PrevHash []byte `json:"prev_hash"`
+
+	/* Hash is the SHA-256 of this event's fields, including PrevHash */
+	Hash []byte `json:"hash"`
+
+	/* Signature, if the chain is configured with a signing key, is the
+	Ed25519 signature over Hash */
+	Signature []byte `json:"signature,omitempty"`
+}
+
+/*
+Sink persists audit Events, e.g. to a local file, syslog, or a webhook
+*/
+type Sink interface {
+	/*
+		Write persists a single Event. Events are always offered to Write
+		in chain order
+	*/
+	Write(e Event) error
+}
+
+/*
+Chain hashes and optionally signs each Event before handing it to one
+or more Sinks. It is safe for concurrent use
+*/
+type Chain struct {
+	sinks   []Sink
+	signKey ed25519.PrivateKey
+
+	mu       sync.Mutex
+	lastHash []byte
+	nextSeq  uint64
+}
+
+/*
+NewChain returns a Chain that writes to sinks. If signKey is non-nil,
+every event is also signed with it
+*/
+func NewChain(signKey ed25519.PrivateKey, sinks ...Sink) *Chain {
+	return &Chain{
+		sinks:    sinks,
+		signKey:  signKey,
+		lastHash: make([]byte, sha256.Size),
+		nextSeq:  1,
+	}
+}
+
+/*
+Record hashes, signs, and persists e, chaining it to the previously
+recorded event
+*/
+func (c *Chain) Record(e Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.Sequence = c.nextSeq
+	e.PrevHash = c.lastHash
+	e.Hash = hashEvent(e)
+
+	if c.signKey != nil {
+		e.Signature = ed25519.Sign(c.signKey, e.Hash)
+	}
+
+	for _, sink := range c.sinks {
+		if err := sink.Write(e); err != nil {
+			return fmt.Errorf("audit sink failed to write event %d: %v", e.Sequence, err)
+		}
+	}
+
+	c.lastHash = e.Hash
+	c.nextSeq++
+
+	return nil
+}
+
+/*
+hashEvent computes the SHA-256 over the event's fields and its
+PrevHash, with Hash and Signature themselves excluded
+*/
+func hashEvent(e Event) []byte {
+	e.Hash = nil
+	e.Signature = nil
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		/* Event only contains JSON-marshalable fields, so this cannot
+		realistically fail */
+		panic(fmt.Sprintf("audit: failed to marshal event for hashing: %v", err))
+	}
+
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+/*
+VerifyChain walks a sequence of events in order and reports the
+sequence number of the first broken link, or 0 if the whole chain is
+intact. A link is broken if an event's PrevHash does not match the
+previous event's Hash, if its Hash does not match its own content, or
+if verifyKey is set and its Signature does not verify
+*/
+func VerifyChain(events []Event, verifyKey ed25519.PublicKey) (brokenAt uint64, err error) {
+	prevHash := make([]byte, sha256.Size)
+
+	for _, e := range events {
+		if !bytesEqual(e.PrevHash, prevHash) {
+			return e.Sequence, fmt.Errorf("event %d does not chain to the previous event", e.Sequence)
+		}
+
+		want := hashEvent(e)
+		if !bytesEqual(e.Hash, want) {
+			return e.Sequence, fmt.Errorf("event %d has been tampered with", e.Sequence)
+		}
+
+		if verifyKey != nil {
+			if !ed25519.Verify(verifyKey, e.Hash, e.Signature) {
+				return e.Sequence, fmt.Errorf("event %d has an invalid signature", e.Sequence)
+			}
+		}
+
+		prevHash = e.Hash
+	}
+
+	return 0, nil
+}
+
+func bytesEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
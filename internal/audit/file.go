@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+FileSink appends each Event as one JSON line to a local file, rotating
+it once it exceeds MaxSizeBytes
+*/
+type FileSink struct {
+	path         string
+	maxSizeBytes int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+/*
+NewFileSink opens (creating if necessary) a JSONL audit log at path,
+rotated to path.1 once it exceeds maxSizeBytes. A maxSizeBytes of 0
+disables rotation
+*/
+func NewFileSink(path string, maxSizeBytes int64) (*FileSink, error) {
+	s := &FileSink{path: path, maxSizeBytes: maxSizeBytes}
+	if err := s.openCurrent(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) openCurrent() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log '%s': %v", s.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat audit log '%s': %v", s.path, err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %v", err)
+	}
+	b = append(b, '\n')
+
+	if s.maxSizeBytes > 0 && s.size+int64(len(b)) > s.maxSizeBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit event to '%s': %v", s.path, err)
+	}
+
+	return nil
+}
+
+/*
+ReadEvents reads back every Event previously appended to the JSONL
+audit log at path by a FileSink, in chain order, for use with
+VerifyChain. rotate only ever keeps one backup, at path+".1", which
+chains into the current file, so that backup is read first, if it
+exists, followed by the current file, giving one continuous chain
+across a rotation
+*/
+func ReadEvents(path string) ([]Event, error) {
+	var events []Event
+
+	rotated, err := readEventsFile(path + ".1")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to open rotated audit log '%s.1': %v", path, err)
+	}
+	events = append(events, rotated...)
+
+	current, err := readEventsFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log '%s': %v", path, err)
+	}
+	events = append(events, current...)
+
+	return events, nil
+}
+
+/*
+readEventsFile reads back every Event appended to a single JSONL audit
+log file
+*/
+func readEventsFile(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse audit event in '%s': %v", path, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log '%s': %v", path, err)
+	}
+
+	return events, nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log before rotation: %v", err)
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %v", err)
+	}
+	return s.openCurrent()
+}
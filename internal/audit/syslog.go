@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+)
+
+/*
+SyslogSink writes each Event as a single JSON-encoded syslog message
+*/
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+/*
+NewSyslogSink dials the local syslog daemon and returns a Sink that
+logs to it under the "eldim" tag
+*/
+func NewSyslogSink() (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "eldim")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	return &SyslogSink{writer: w}, nil
+}
+
+func (s *SyslogSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %v", err)
+	}
+	return s.writer.Info(string(b))
+}
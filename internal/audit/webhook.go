@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+/*
+WebhookSink POSTs each Event, JSON-encoded, to a configured URL
+*/
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+/*
+NewWebhookSink returns a Sink that POSTs events to url
+*/
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %v", err)
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to POST audit event to webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+Package auth implements pluggable authentication for eldim clients.
+
+A client authenticates to eldim by satisfying a Policy, which is built
+out of one or more Verifiers (static password, source IP allowlist,
+OIDC ID token, signed JWT, or mTLS client certificate). Policies can be
+composed with AND/OR semantics so an operator can, for example, require
+mTLS together with either OIDC or a password.
+*/
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+/*
+Request carries everything a Verifier may need to authenticate an
+incoming upload request
+*/
+type Request struct {
+	/* Remote IP address of the connecting client, without port */
+	RemoteIP string
+
+	/* Password supplied via the request, if any */
+	Password string
+
+	/* Bearer token supplied via the Authorization header, if any */
+	BearerToken string
+
+	/* Verified client certificate chain, set when the HTTP server is
+	configured for (optional) mTLS */
+	PeerCertificates []*x509.Certificate
+}
+
+/*
+RequestFromHTTP builds a Request out of an incoming *http.Request,
+extracting the remote IP, bearer token, and peer certificates
+*/
+func RequestFromHTTP(r *http.Request, remoteIP string) *Request {
+	req := &Request{
+		RemoteIP: remoteIP,
+	}
+
+	if bearer := r.Header.Get("Authorization"); len(bearer) > 7 && bearer[:7] == "Bearer " {
+		req.BearerToken = bearer[7:]
+	}
+
+	if r.TLS != nil {
+		req.PeerCertificates = r.TLS.PeerCertificates
+	}
+
+	return req
+}
+
+/*
+Verifier authenticates a single Request and returns nil if the request
+is authenticated, or an error describing why it was rejected
+*/
+type Verifier interface {
+	/*
+		Verify returns nil if req satisfies this Verifier
+	*/
+	Verify(req *Request) error
+
+	/*
+		Method returns a short, human readable name for this Verifier,
+		used in logs and the audit trail
+	*/
+	Method() string
+}
+
+/*
+Policy is a boolean expression over one or more Verifiers
+*/
+type Policy interface {
+	Verifier
+}
+
+/*
+allOf is a Policy that requires every child Verifier to succeed
+*/
+type allOf struct {
+	children []Verifier
+}
+
+/*
+AllOf returns a Policy that is satisfied only when every verifier in
+verifiers succeeds
+*/
+func AllOf(verifiers ...Verifier) Policy {
+	return &allOf{children: verifiers}
+}
+
+func (a *allOf) Verify(req *Request) error {
+	for _, v := range a.children {
+		if err := v.Verify(req); err != nil {
+			return fmt.Errorf("%s: %v", v.Method(), err)
+		}
+	}
+	return nil
+}
+
+func (a *allOf) Method() string {
+	return "all"
+}
+
+/*
+anyOf is a Policy that requires at least one child Verifier to succeed
+*/
+type anyOf struct {
+	children []Verifier
+}
+
+/*
+AnyOf returns a Policy that is satisfied when any one of verifiers
+succeeds
+*/
+func AnyOf(verifiers ...Verifier) Policy {
+	return &anyOf{children: verifiers}
+}
+
+func (a *anyOf) Verify(req *Request) error {
+	if len(a.children) == 0 {
+		return fmt.Errorf("no verifiers configured")
+	}
+
+	var lastErr error
+	for _, v := range a.children {
+		lastErr = v.Verify(req)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("none of %d verifiers succeeded, last error: %v", len(a.children), lastErr)
+}
+
+func (a *anyOf) Method() string {
+	return "any"
+}
+
+/*
+not is a Policy that requires its child Verifier to fail
+*/
+type not struct {
+	child Verifier
+}
+
+/*
+NotOf returns a Policy that is satisfied only when v is not satisfied
+*/
+func NotOf(v Verifier) Policy {
+	return &not{child: v}
+}
+
+func (n *not) Verify(req *Request) error {
+	if err := n.child.Verify(req); err == nil {
+		return fmt.Errorf("%s: succeeded but was required not to", n.child.Method())
+	}
+	return nil
+}
+
+func (n *not) Method() string {
+	return "not"
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+)
+
+/*
+IPVerifier authenticates a client by matching its source address
+against an allowlist of IPv4 and IPv6 addresses
+*/
+type IPVerifier struct {
+	allowed []net.IP
+}
+
+/*
+NewIPVerifier returns a Verifier that is satisfied when the Request's
+remote IP is one of allowed
+*/
+func NewIPVerifier(allowed []net.IP) *IPVerifier {
+	return &IPVerifier{allowed: allowed}
+}
+
+func (i *IPVerifier) Verify(req *Request) error {
+	remote := net.ParseIP(req.RemoteIP)
+	if remote == nil {
+		return fmt.Errorf("could not parse remote IP: %s", req.RemoteIP)
+	}
+
+	for _, ip := range i.allowed {
+		if ip.Equal(remote) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not in the allowlist", req.RemoteIP)
+}
+
+func (i *IPVerifier) Method() string {
+	return "ip"
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jose "github.com/square/go-jose/v3"
+)
+
+/*
+jwksCacheTTL controls how long a fetched JWKS document is reused before
+it is fetched again
+*/
+const jwksCacheTTL = 5 * time.Minute
+
+/*
+JWKSCache fetches and caches the signing keys published at a JWKS URL,
+refreshing them periodically
+*/
+type JWKSCache struct {
+	url string
+
+	mu      sync.Mutex
+	fetched time.Time
+	keys    []interface{}
+}
+
+/*
+NewJWKSCache returns a JWKSCache that lazily fetches keys from url on
+first use
+*/
+func NewJWKSCache(url string) *JWKSCache {
+	return &JWKSCache{url: url}
+}
+
+/*
+Keys returns the current signing keys, fetching or refreshing them from
+the JWKS URL if the cache is empty or stale
+*/
+func (c *JWKSCache) Keys() ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.keys) > 0 && time.Since(c.fetched) < jwksCacheTTL {
+		return c.keys, nil
+	}
+
+	resp, err := http.Get(c.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var jwks jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	var keys []interface{}
+	for _, k := range jwks.Keys {
+		keys = append(keys, k.Key)
+	}
+
+	c.keys = keys
+	c.fetched = time.Now()
+
+	return c.keys, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import "fmt"
+
+/*
+MTLSConfig configures mutual TLS client certificate authentication for
+a single client
+*/
+type MTLSConfig struct {
+	/* Accepted certificate Common Names. Empty means any CN is accepted,
+	as long as the certificate chains to the server's configured
+	client CA */
+	AllowedCNs []string `yaml:"allowedcns"`
+
+	/* Accepted DNS Subject Alternative Names. Empty means SANs are not
+	checked */
+	AllowedSANs []string `yaml:"allowedsans"`
+}
+
+/*
+Validate checks that the mTLS configuration is internally consistent
+*/
+func (c *MTLSConfig) Validate() error {
+	return nil
+}
+
+/*
+MTLSVerifier authenticates a client by its already-chain-verified TLS
+client certificate, as presented to the HTTP server
+*/
+type MTLSVerifier struct {
+	conf MTLSConfig
+}
+
+/*
+NewMTLSVerifier returns a Verifier that checks the Request's peer
+certificate against conf. Chain and expiry validation is expected to
+have already happened in the HTTP server's TLS handshake; this
+Verifier only checks identity
+*/
+func NewMTLSVerifier(conf MTLSConfig) *MTLSVerifier {
+	return &MTLSVerifier{conf: conf}
+}
+
+func (m *MTLSVerifier) Verify(req *Request) error {
+	if len(req.PeerCertificates) == 0 {
+		return fmt.Errorf("no client certificate presented")
+	}
+	leaf := req.PeerCertificates[0]
+
+	if len(m.conf.AllowedCNs) > 0 {
+		if !contains(m.conf.AllowedCNs, leaf.Subject.CommonName) {
+			return fmt.Errorf("certificate CN '%s' is not allowed", leaf.Subject.CommonName)
+		}
+	}
+
+	if len(m.conf.AllowedSANs) > 0 {
+		var matched bool
+		for _, san := range leaf.DNSNames {
+			if contains(m.conf.AllowedSANs, san) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("no SAN of the certificate is allowed")
+		}
+	}
+
+	return nil
+}
+
+func (m *MTLSVerifier) Method() string {
+	return "mtls"
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
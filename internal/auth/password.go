@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+/*
+PasswordVerifier authenticates a client by comparing a shared secret
+using a constant time comparison
+*/
+type PasswordVerifier struct {
+	password string
+}
+
+/*
+NewPasswordVerifier returns a Verifier that is satisfied when the
+Request carries the configured password
+*/
+func NewPasswordVerifier(password string) *PasswordVerifier {
+	return &PasswordVerifier{password: password}
+}
+
+func (p *PasswordVerifier) Verify(req *Request) error {
+	if p.password == "" {
+		return fmt.Errorf("no password configured")
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Password), []byte(p.password)) != 1 {
+		return fmt.Errorf("password does not match")
+	}
+	return nil
+}
+
+func (p *PasswordVerifier) Method() string {
+	return "password"
+}
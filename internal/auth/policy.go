@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ParsePolicy parses a policy expression such as "mtls AND (oidc OR
+password)" into a Policy, resolving each identifier against the
+supplied verifiers map. Supported operators are AND, OR and NOT, with
+parentheses for grouping; operators are case-insensitive
+*/
+func ParsePolicy(expr string, verifiers map[string]Verifier) (Policy, error) {
+	p := &policyParser{
+		tokens:    tokenizePolicy(expr),
+		verifiers: verifiers,
+	}
+
+	policy, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token '%s' in policy expression", p.tokens[p.pos])
+	}
+
+	return policy, nil
+}
+
+func tokenizePolicy(expr string) []string {
+	replacer := strings.NewReplacer("(", " ( ", ")", " ) ")
+	return strings.Fields(replacer.Replace(expr))
+}
+
+type policyParser struct {
+	tokens    []string
+	pos       int
+	verifiers map[string]Verifier
+}
+
+func (p *policyParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *policyParser) parseOr() (Policy, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := []Verifier{left}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, right)
+	}
+
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return AnyOf(terms...), nil
+}
+
+func (p *policyParser) parseAnd() (Policy, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := []Verifier{left}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, right)
+	}
+
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return AllOf(terms...), nil
+}
+
+func (p *policyParser) parseTerm() (Policy, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of policy expression")
+	}
+
+	if strings.EqualFold(tok, "NOT") {
+		p.pos++
+		inner, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return NotOf(inner), nil
+	}
+
+	if tok == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis in policy expression")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	p.pos++
+	v, ok := p.verifiers[strings.ToLower(tok)]
+	if !ok {
+		return nil, fmt.Errorf("policy references unknown verifier '%s'", tok)
+	}
+	return asPolicy(v), nil
+}
+
+func asPolicy(v Verifier) Policy {
+	if policy, ok := v.(Policy); ok {
+		return policy
+	}
+	return AllOf(v)
+}
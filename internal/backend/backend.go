@@ -0,0 +1,39 @@
+/*
+Package backend defines the common interface every eldim storage
+backend (Swift, GCS, S3, ...) implements, and the replication layer
+that fans an upload out to one or more of them.
+*/
+package backend
+
+import (
+	"context"
+	"io"
+)
+
+/*
+Client is implemented by every storage backend eldim can upload to
+*/
+type Client interface {
+	/*
+		Name returns the configured name of this backend, as it appears
+		in the configuration file
+	*/
+	Name() string
+
+	/*
+		Type returns the backend's type, e.g. "swift", "gcs" or "s3"
+	*/
+	Type() string
+
+	/*
+		Tags returns the set of tags this backend was configured with,
+		used to select it under a "by-tag" ReplicationPolicy
+	*/
+	Tags() []string
+
+	/*
+		Upload uploads size bytes read from r to the backend, storing it
+		as name. It must not retain r after returning
+	*/
+	Upload(ctx context.Context, name string, r io.Reader, size int64) error
+}
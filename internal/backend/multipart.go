@@ -0,0 +1,44 @@
+package backend
+
+import (
+	"context"
+	"io"
+)
+
+/*
+MultipartClient is implemented by backends that can accept an upload as
+a series of chunks instead of all at once, so the server only needs a
+small fixed buffer regardless of object size. It models the
+CreateMultipartUpload/UploadPart/CompleteMultipartUpload/AbortMultipartUpload
+flow S3 uses natively; internal/swift and internal/gcs are expected to
+implement it in terms of segmented objects and resumable uploads,
+respectively
+*/
+type MultipartClient interface {
+	Client
+
+	/*
+		CreateMultipartUpload begins a new multipart upload for name, and
+		returns an opaque upload ID the backend can later resume the
+		upload from
+	*/
+	CreateMultipartUpload(ctx context.Context, name string) (uploadID string, err error)
+
+	/*
+		UploadPart uploads a single part of size bytes read from r, at
+		partNumber (1-indexed, in order), to the multipart upload uploadID
+	*/
+	UploadPart(ctx context.Context, uploadID string, partNumber int, r io.Reader, size int64) error
+
+	/*
+		CompleteMultipartUpload commits all parts previously uploaded to
+		uploadID, making the object available under name
+	*/
+	CompleteMultipartUpload(ctx context.Context, uploadID string) error
+
+	/*
+		AbortMultipartUpload discards uploadID and any parts already
+		uploaded to it
+	*/
+	AbortMultipartUpload(ctx context.Context, uploadID string) error
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+PolicyKind identifies one of the replication policies eldim supports
+*/
+type PolicyKind string
+
+const (
+	/* PolicyAll requires every configured backend to accept the upload */
+	PolicyAll PolicyKind = "all"
+
+	/* PolicyQuorum requires at least Quorum backends to accept the
+	upload, out of however many are configured */
+	PolicyQuorum PolicyKind = "quorum"
+
+	/* PolicyPrimaryAsyncReplica requires only the first configured
+	backend to accept the upload synchronously; the rest are uploaded
+	to in the background, and queued for reconciliation on failure */
+	PolicyPrimaryAsyncReplica PolicyKind = "primary+async-replica"
+
+	/* PolicyByTag requires every backend tagged with Tag to accept the
+	upload */
+	PolicyByTag PolicyKind = "by-tag"
+)
+
+/*
+Policy is a parsed replication policy, as configured via the
+`replication.policy` YAML field
+*/
+type Policy struct {
+	Kind   PolicyKind
+	Quorum int
+	Tag    string
+}
+
+/*
+ParsePolicy parses a replication policy expression, e.g. "all",
+"quorum:2", "primary+async-replica", or "by-tag:offsite"
+*/
+func ParsePolicy(s string) (Policy, error) {
+	switch {
+	case s == string(PolicyAll):
+		return Policy{Kind: PolicyAll}, nil
+
+	case s == string(PolicyPrimaryAsyncReplica):
+		return Policy{Kind: PolicyPrimaryAsyncReplica}, nil
+
+	case strings.HasPrefix(s, "quorum:"):
+		k, err := strconv.Atoi(strings.TrimPrefix(s, "quorum:"))
+		if err != nil || k < 1 {
+			return Policy{}, fmt.Errorf("invalid quorum size in policy '%s'", s)
+		}
+		return Policy{Kind: PolicyQuorum, Quorum: k}, nil
+
+	case strings.HasPrefix(s, "by-tag:"):
+		tag := strings.TrimPrefix(s, "by-tag:")
+		if tag == "" {
+			return Policy{}, fmt.Errorf("by-tag policy requires a tag")
+		}
+		return Policy{Kind: PolicyByTag, Tag: tag}, nil
+
+	default:
+		return Policy{}, fmt.Errorf("unknown replication policy '%s'", s)
+	}
+}
+
+/*
+Select returns the subset of clients this policy applies to, and how
+many of them must succeed for the upload as a whole to succeed
+*/
+func (p Policy) Select(clients []Client) (selected []Client, required int, err error) {
+	switch p.Kind {
+	case PolicyAll:
+		return clients, len(clients), nil
+
+	case PolicyQuorum:
+		if p.Quorum > len(clients) {
+			return nil, 0, fmt.Errorf("quorum %d exceeds the %d configured backends", p.Quorum, len(clients))
+		}
+		return clients, p.Quorum, nil
+
+	case PolicyPrimaryAsyncReplica:
+		if len(clients) == 0 {
+			return nil, 0, fmt.Errorf("primary+async-replica requires at least one backend")
+		}
+		return clients, 1, nil
+
+	case PolicyByTag:
+		for _, c := range clients {
+			for _, t := range c.Tags() {
+				if t == p.Tag {
+					selected = append(selected, c)
+					break
+				}
+			}
+		}
+		if len(selected) == 0 {
+			return nil, 0, fmt.Errorf("no backend is tagged '%s'", p.Tag)
+		}
+		return selected, len(selected), nil
+
+	default:
+		return nil, 0, fmt.Errorf("unknown replication policy kind '%s'", p.Kind)
+	}
+}
@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+/*
+reconcileBucket is the BoltDB bucket eldim stores pending replicas in
+*/
+var reconcileBucket = []byte("pending-replicas")
+
+/*
+pendingReplica is a single object that still needs to be written to a
+backend that was unavailable when the original upload completed
+*/
+type pendingReplica struct {
+	Backend  string    `json:"backend"`
+	Name     string    `json:"name"`
+	Body     []byte    `json:"body"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+/*
+Reconciler persists replicas that failed to write to their backend in
+a local BoltDB queue, and periodically retries them in the background
+until they succeed
+*/
+type Reconciler struct {
+	db       *bolt.DB
+	lookup   map[string]Client
+	interval time.Duration
+}
+
+/*
+NewReconciler opens (creating if necessary) a BoltDB queue at path, and
+returns a Reconciler that can retry against any of clients
+*/
+func NewReconciler(path string, clients []Client) (*Reconciler, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reconciliation queue '%s': %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(reconcileBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize reconciliation queue: %v", err)
+	}
+
+	lookup := make(map[string]Client)
+	for _, c := range clients {
+		lookup[c.Name()] = c
+	}
+
+	return &Reconciler{
+		db:       db,
+		lookup:   lookup,
+		interval: time.Minute,
+	}, nil
+}
+
+/*
+Enqueue persists a replica that needs to be retried against backend
+*/
+func (rec *Reconciler) Enqueue(backend, name string, body []byte) {
+	replica := pendingReplica{
+		Backend:  backend,
+		Name:     name,
+		Body:     body,
+		QueuedAt: time.Now(),
+	}
+
+	b, err := json.Marshal(replica)
+	if err != nil {
+		logrus.Errorf("Failed to marshal pending replica for '%s' on '%s': %v", name, backend, err)
+		return
+	}
+
+	err = rec.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(reconcileBucket)
+		id, _ := bucket.NextSequence()
+		return bucket.Put(itob(id), b)
+	})
+	if err != nil {
+		logrus.Errorf("Failed to enqueue pending replica for '%s' on '%s': %v", name, backend, err)
+	}
+}
+
+/*
+Run periodically retries every queued replica, until stopCtx is
+canceled. It is meant to be run in its own goroutine
+*/
+func (rec *Reconciler) Run(stopCtx context.Context) {
+	ticker := time.NewTicker(rec.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCtx.Done():
+			return
+		case <-ticker.C:
+			rec.reconcileOnce(stopCtx)
+		}
+	}
+}
+
+func (rec *Reconciler) reconcileOnce(ctx context.Context) {
+	var toRetry []struct {
+		key     []byte
+		replica pendingReplica
+	}
+
+	err := rec.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(reconcileBucket)
+		return bucket.ForEach(func(k, v []byte) error {
+			var replica pendingReplica
+			if err := json.Unmarshal(v, &replica); err != nil {
+				return nil
+			}
+			key := append([]byte(nil), k...)
+			toRetry = append(toRetry, struct {
+				key     []byte
+				replica pendingReplica
+			}{key, replica})
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.Errorf("Failed to scan reconciliation queue: %v", err)
+		return
+	}
+
+	for _, item := range toRetry {
+		client, ok := rec.lookup[item.replica.Backend]
+		if !ok {
+			continue
+		}
+
+		uploadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := client.Upload(uploadCtx, item.replica.Name, bytes.NewReader(item.replica.Body), int64(len(item.replica.Body)))
+		cancel()
+		if err != nil {
+			logrus.Warnf("Reconciliation of '%s' on '%s' still failing: %v", item.replica.Name, item.replica.Backend, err)
+			continue
+		}
+
+		err = rec.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(reconcileBucket).Delete(item.key)
+		})
+		if err != nil {
+			logrus.Errorf("Failed to remove reconciled replica from queue: %v", err)
+		}
+	}
+}
+
+/*
+Close closes the underlying BoltDB queue
+*/
+func (rec *Reconciler) Close() error {
+	return rec.db.Close()
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	for i := 7; i >= 0; i-- {
+		b[i] = byte(v & 0xff)
+		v >>= 8
+	}
+	return b
+}
@@ -0,0 +1,321 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/daknob/eldim/internal/metrics"
+)
+
+/*
+BackendResult is the outcome of uploading to a single backend
+*/
+type BackendResult struct {
+	Backend  string
+	Type     string
+	Err      error
+	Duration time.Duration
+}
+
+/*
+ReplicationResult is returned to the HTTP handler once an upload has
+been replicated according to its Policy, so the client can see exactly
+which backends succeeded
+*/
+type ReplicationResult struct {
+	Results  []BackendResult
+	Required int
+}
+
+/*
+OK reports whether enough backends succeeded to satisfy the policy
+*/
+func (r ReplicationResult) OK() bool {
+	return r.Succeeded() >= r.Required
+}
+
+/*
+Succeeded returns how many backends accepted the upload
+*/
+func (r ReplicationResult) Succeeded() int {
+	var n int
+	for _, res := range r.Results {
+		if res.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+Replicator fans an upload out to a set of backend.Client according to
+a Policy, retrying each backend a bounded number of times with
+exponential backoff, and optionally queuing failed replicas for later
+reconciliation
+*/
+type Replicator struct {
+	clients []Client
+	policy  Policy
+
+	/* PerBackendTimeout bounds how long a single backend's Upload may
+	take before it is considered failed */
+	PerBackendTimeout time.Duration
+
+	/* Retries is how many additional attempts are made against a
+	backend after its first attempt fails */
+	Retries int
+
+	/* Backoff is the base delay between retries, doubled after each
+	attempt */
+	Backoff time.Duration
+
+	/* Reconciler, if set, is handed any backend that could not be
+	written to, so it can be retried later in the background */
+	Reconciler *Reconciler
+
+	/* Recorder, if set, receives an ObserveUpload call for every
+	backend this Replicator uploads to */
+	Recorder metrics.Recorder
+}
+
+/*
+NewReplicator returns a Replicator that fans uploads out to clients
+according to policy, with sensible per-backend timeout and retry
+defaults
+*/
+func NewReplicator(clients []Client, policy Policy) *Replicator {
+	return &Replicator{
+		clients:           clients,
+		policy:            policy,
+		PerBackendTimeout: 30 * time.Second,
+		Retries:           2,
+		Backoff:           500 * time.Millisecond,
+	}
+}
+
+/*
+Upload replicates size bytes read from r to the backends selected by
+the Replicator's Policy on behalf of client, returning a
+ReplicationResult describing which backends succeeded. Under
+PolicyPrimaryAsyncReplica, only the primary backend is uploaded to
+before Upload returns; the remaining backends are uploaded to in the
+background.
+
+r is spooled to a temporary file once, rather than held in memory, so
+that replicating to N backends costs N copy-buffers rather than N
+copies of the whole object, keeping Upload's memory use independent of
+object size and of how many backends a policy selects
+*/
+func (rep *Replicator) Upload(ctx context.Context, client, name string, r io.Reader, size int64) (ReplicationResult, error) {
+	selected, required, err := rep.policy.Select(rep.clients)
+	if err != nil {
+		return ReplicationResult{}, fmt.Errorf("failed to select backends: %v", err)
+	}
+
+	spool, err := newSpoolFile(r)
+	if err != nil {
+		return ReplicationResult{}, fmt.Errorf("failed to spool upload body: %v", err)
+	}
+
+	if rep.policy.Kind == PolicyPrimaryAsyncReplica && len(selected) > 1 {
+		primary, replicas := selected[0], selected[1:]
+
+		result := rep.uploadTo(ctx, []Client{primary}, client, name, spool)
+
+		go func() {
+			defer spool.release()
+			rep.uploadAsync(replicas, client, name, spool)
+		}()
+
+		result.Required = required
+		return result, nil
+	}
+	defer spool.release()
+
+	result := rep.uploadTo(ctx, selected, client, name, spool)
+	result.Required = required
+	return result, nil
+}
+
+/*
+PrimaryMultipartClient returns the first backend the Replicator's
+Policy would select that also implements MultipartClient, for callers
+like the resumable-upload session handlers that stream chunks to a
+single backend directly instead of going through Upload. Backends are
+tried in the policy's selection order, so the result is the primary
+backend only if the primary implements MultipartClient; otherwise it is
+the first later one that does
+*/
+func (rep *Replicator) PrimaryMultipartClient() (MultipartClient, error) {
+	selected, _, err := rep.policy.Select(rep.clients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select backends: %v", err)
+	}
+
+	for _, c := range selected {
+		if mc, ok := c.(MultipartClient); ok {
+			return mc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no backend selected by the replication policy supports resumable uploads")
+}
+
+/*
+uploadTo uploads spool to every client in parallel, and waits for all
+of them to finish
+*/
+func (rep *Replicator) uploadTo(ctx context.Context, clients []Client, client, name string, spool *spoolFile) ReplicationResult {
+	results := make([]BackendResult, len(clients))
+
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c Client) {
+			defer wg.Done()
+			results[i] = rep.uploadWithRetry(ctx, c, client, name, spool)
+			if results[i].Err != nil && rep.Reconciler != nil {
+				rep.enqueueReplica(c.Name(), name, spool)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return ReplicationResult{Results: results}
+}
+
+/*
+uploadAsync uploads spool to every client sequentially, in the
+background, without blocking the caller. Failures are queued for
+reconciliation rather than returned
+*/
+func (rep *Replicator) uploadAsync(clients []Client, client, name string, spool *spoolFile) {
+	for _, c := range clients {
+		result := rep.uploadWithRetry(context.Background(), c, client, name, spool)
+		if result.Err != nil && rep.Reconciler != nil {
+			rep.enqueueReplica(c.Name(), name, spool)
+		}
+	}
+}
+
+/*
+enqueueReplica reads the spooled body back into memory so it can be
+persisted to the reconciliation queue. This only happens for the
+backends that failed, rather than for every upload
+*/
+func (rep *Replicator) enqueueReplica(backendName, name string, spool *spoolFile) {
+	body, err := spool.readAll()
+	if err != nil {
+		return
+	}
+	rep.Reconciler.Enqueue(backendName, name, body)
+}
+
+/*
+uploadWithRetry uploads spool to a single backend on behalf of client,
+retrying up to Retries additional times with exponential backoff, and
+recording the outcome with Recorder if one is configured
+*/
+func (rep *Replicator) uploadWithRetry(ctx context.Context, c Client, client, name string, spool *spoolFile) BackendResult {
+	start := time.Now()
+
+	var err error
+	backoff := rep.Backoff
+	for attempt := 0; attempt <= rep.Retries; attempt++ {
+		f, openErr := spool.open()
+		if openErr != nil {
+			err = openErr
+			break
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, rep.PerBackendTimeout)
+		err = c.Upload(attemptCtx, name, f, spool.size)
+		cancel()
+		f.Close()
+
+		if err == nil {
+			break
+		}
+		if attempt < rep.Retries {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	result := BackendResult{
+		Backend:  c.Name(),
+		Type:     c.Type(),
+		Err:      err,
+		Duration: time.Since(start),
+	}
+
+	if rep.Recorder != nil {
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+		}
+		rep.Recorder.ObserveUpload(c.Type(), c.Name(), client, outcome, spool.size, result.Duration.Seconds())
+	}
+
+	return result
+}
+
+/*
+spoolFile holds an upload body spooled to a temporary file, so that
+replicating it to multiple backends, and retrying a backend, never
+requires more than one open file handle and a copy buffer in memory
+regardless of the object's size
+*/
+type spoolFile struct {
+	path string
+	size int64
+}
+
+/*
+newSpoolFile copies r to a new temporary file and returns a spoolFile
+referencing it
+*/
+func newSpoolFile(r io.Reader) (*spoolFile, error) {
+	f, err := ioutil.TempFile("", "eldim-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %v", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to spool upload body to disk: %v", err)
+	}
+
+	return &spoolFile{path: f.Name(), size: n}, nil
+}
+
+/*
+open returns a fresh, independent read handle onto the spooled body,
+so concurrent and retried uploads never share a read position
+*/
+func (s *spoolFile) open() (*os.File, error) {
+	return os.Open(s.path)
+}
+
+/*
+readAll reads the entire spooled body back into memory, for the rare
+case (reconciliation) that needs to hand it off as a []byte
+*/
+func (s *spoolFile) readAll() ([]byte, error) {
+	return ioutil.ReadFile(s.path)
+}
+
+/*
+release removes the underlying temporary file. It must only be called
+once every uploader of this spoolFile has finished with it
+*/
+func (s *spoolFile) release() {
+	os.Remove(s.path)
+}
@@ -0,0 +1,75 @@
+package secretref
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+)
+
+/*
+AgeResolver resolves "age:path/to/bundle.age#field" references by
+decrypting the age-encrypted file at path with the configured
+identities, then, if a "#field" fragment is present, looking up field
+as a "key: value" line within the decrypted bundle. Without a fragment,
+the whole decrypted content is used as the secret, trimmed of a single
+trailing newline.
+
+This lets an operator ship one age-encrypted bundle containing every
+backend credential, and reference individual fields from it across the
+configuration file
+*/
+type AgeResolver struct {
+	identities []age.Identity
+}
+
+/*
+NewAgeResolver returns an AgeResolver that decrypts with identities
+*/
+func NewAgeResolver(identities []age.Identity) *AgeResolver {
+	return &AgeResolver{identities: identities}
+}
+
+func (r *AgeResolver) Resolve(ctx context.Context, value string) (string, error) {
+	path, field := value, ""
+	if idx := strings.Index(value, "#"); idx >= 0 {
+		path, field = value[:idx], value[idx+1:]
+	}
+
+	ciphertext, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read age bundle '%s': %v", path, err)
+	}
+
+	plaintext, err := age.Decrypt(bytes.NewReader(ciphertext), r.identities...)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt age bundle '%s': %v", path, err)
+	}
+
+	content, err := ioutil.ReadAll(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("failed to read decrypted age bundle '%s': %v", path, err)
+	}
+
+	if field == "" {
+		return strings.TrimSuffix(string(content), "\n"), nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		if strings.TrimSpace(line[:idx]) == field {
+			return strings.TrimSpace(line[idx+1:]), nil
+		}
+	}
+
+	return "", fmt.Errorf("field '%s' not found in age bundle '%s'", field, path)
+}
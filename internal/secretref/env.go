@@ -0,0 +1,20 @@
+package secretref
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+/*
+EnvResolver resolves "env:NAME" references from the process environment
+*/
+type EnvResolver struct{}
+
+func (EnvResolver) Resolve(ctx context.Context, value string) (string, error) {
+	secret, ok := os.LookupEnv(value)
+	if !ok {
+		return "", fmt.Errorf("environment variable '%s' is not set", value)
+	}
+	return secret, nil
+}
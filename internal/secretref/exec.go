@@ -0,0 +1,35 @@
+package secretref
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+/*
+ExecResolver resolves "exec:command arg1 arg2" references by running
+the command and using its trimmed standard output as the secret. The
+command and its arguments are split on whitespace; they are not passed
+through a shell
+*/
+type ExecResolver struct{}
+
+func (ExecResolver) Resolve(ctx context.Context, value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("exec reference does not specify a command")
+	}
+
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("command '%s' failed: %v", fields[0], err)
+	}
+
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
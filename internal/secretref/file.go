@@ -0,0 +1,22 @@
+package secretref
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+)
+
+/*
+FileResolver resolves "file:/path" references by reading the named
+file and trimming a single trailing newline, as is conventional for
+secrets mounted by orchestrators such as Docker or Kubernetes
+*/
+type FileResolver struct{}
+
+func (FileResolver) Resolve(ctx context.Context, value string) (string, error) {
+	b, err := ioutil.ReadFile(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
@@ -0,0 +1,90 @@
+/*
+Package secretref resolves indirect references to secrets so that
+eldim's configuration file does not need to contain plaintext
+credentials. A field's YAML value can be a literal, or a reference of
+the form "scheme:value", such as "env:ELDIM_S3_SECRET" or
+"age:/etc/eldim/secrets.age#s3key". References are resolved once, when
+the configuration is loaded, before validation.
+*/
+package secretref
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/*
+Resolver resolves a single secret reference, as configured for one
+scheme (e.g. "env", "file", "exec", "age")
+*/
+type Resolver interface {
+	/*
+		Resolve returns the plaintext secret referred to by value, which
+		is the reference with the scheme prefix already stripped
+	*/
+	Resolve(ctx context.Context, value string) (string, error)
+}
+
+/*
+resolvers holds the registered Resolver for each supported scheme
+*/
+var resolvers = map[string]Resolver{
+	"env":  EnvResolver{},
+	"file": FileResolver{},
+	"exec": ExecResolver{},
+}
+
+/*
+RegisterAgeResolver installs the age-backed Resolver for the "age"
+scheme, configured with the identities eldim should attempt decryption
+with. It is registered separately from the other built-in resolvers
+because it requires the operator's age identities to be available
+first
+*/
+func RegisterAgeResolver(r Resolver) {
+	resolvers["age"] = r
+}
+
+/*
+Resolve resolves ref if it is a recognized "scheme:value" reference,
+and returns ref unchanged otherwise, so that plaintext configuration
+values keep working without any reference syntax
+*/
+func Resolve(ctx context.Context, ref string) (string, error) {
+	scheme, value, ok := splitRef(ref)
+	if !ok {
+		return ref, nil
+	}
+
+	r, ok := resolvers[scheme]
+	if !ok {
+		return "", fmt.Errorf("unknown secret reference scheme '%s'", scheme)
+	}
+
+	secret, err := r.Resolve(ctx, value)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve '%s' reference: %v", scheme, err)
+	}
+
+	return secret, nil
+}
+
+/*
+splitRef splits a reference into its scheme and value, recognizing
+only the schemes eldim ships resolvers for, so that a plaintext value
+containing a colon (such as a URL) is not mistaken for a reference
+*/
+func splitRef(ref string) (scheme string, value string, ok bool) {
+	idx := strings.Index(ref, ":")
+	if idx < 0 {
+		return "", "", false
+	}
+	scheme, value = ref[:idx], ref[idx+1:]
+
+	if _, known := resolvers[scheme]; !known && scheme != "age" {
+		return "", "", false
+	}
+
+	return scheme, value, true
+}
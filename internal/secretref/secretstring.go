@@ -0,0 +1,52 @@
+package secretref
+
+import "context"
+
+/*
+SecretString is a configuration field whose YAML value may either be a
+plaintext literal, or a "scheme:value" secret reference resolved via
+Resolve before the configuration is validated
+*/
+type SecretString struct {
+	raw      string
+	resolved string
+
+	/* resolvedSet records whether Resolve has run, so that a reference
+	that legitimately resolves to an empty string is not mistaken for
+	one that was never resolved */
+	resolvedSet bool
+}
+
+/*
+UnmarshalYAML stores the raw YAML scalar without resolving it, so that
+resolution can happen once, in a single pass, after the whole
+configuration file has been parsed
+*/
+func (s *SecretString) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	return unmarshal(&s.raw)
+}
+
+/*
+Resolve resolves the secret reference, if any, and caches the result
+for subsequent calls to String
+*/
+func (s *SecretString) Resolve(ctx context.Context) error {
+	v, err := Resolve(ctx, s.raw)
+	if err != nil {
+		return err
+	}
+	s.resolved = v
+	s.resolvedSet = true
+	return nil
+}
+
+/*
+String returns the resolved plaintext secret. It returns the raw,
+unresolved value if Resolve has not been called yet
+*/
+func (s SecretString) String() string {
+	if s.resolvedSet {
+		return s.resolved
+	}
+	return s.raw
+}
@@ -0,0 +1,338 @@
+/*
+Package session implements eldim's resumable upload sessions, modeled
+on the tus.io protocol: a client opens a session declaring the total
+upload size, then streams the object in chunks via a series of PATCH
+requests, each extending the session's offset. Every chunk is piped
+through an age encryption stream directly into the backend's multipart
+upload, so the server only ever holds one chunk in memory regardless
+of the object's total size.
+*/
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"github.com/daknob/eldim/internal/backend"
+)
+
+/*
+defaultPartSize is how many encrypted bytes are buffered before they
+are handed to the backend as one multipart part. It must be at least
+as large as the smallest part size a backend's multipart API accepts
+(S3 requires 5 MiB for all but the last part)
+*/
+const defaultPartSize = 8 * 1024 * 1024
+
+/*
+Session is a single in-progress resumable upload
+*/
+type Session struct {
+	ID   string
+	Name string
+
+	/* Owner is the name of the client that created this session; only
+	that client may PATCH chunks to it */
+	Owner string
+
+	client   backend.MultipartClient
+	uploadID string
+
+	expectedSize int64
+	partSize     int64
+
+	ageWriter io.WriteCloser
+	pipeW     *io.PipeWriter
+
+	uploadErr chan error
+
+	/* ttl is the idle time after which Sweep removes this session */
+	ttl time.Duration
+
+	mu        sync.Mutex
+	received  int64
+	expiresAt time.Time
+	done      bool
+}
+
+/*
+Manager tracks every open Session. It is safe for concurrent use
+*/
+type Manager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+
+	/* PartSize is how many encrypted bytes are buffered per backend
+	part. Defaults to 8 MiB */
+	PartSize int64
+
+	/* TTL is how long an idle session is kept before Sweep removes it */
+	TTL time.Duration
+}
+
+/*
+NewManager returns a Manager with eldim's default part size and
+session TTL
+*/
+func NewManager() *Manager {
+	return &Manager{
+		sessions: make(map[string]*Session),
+		PartSize: defaultPartSize,
+		TTL:      time.Hour,
+	}
+}
+
+/*
+Create opens a new resumable upload session for name, of the declared
+total size, against client, encrypting to recipients as the chunks
+arrive. owner is the name of the client opening the session; only that
+client may PATCH chunks to the returned Session
+*/
+func (m *Manager) Create(ctx context.Context, client backend.MultipartClient, owner, name string, size int64, recipients []age.Recipient) (*Session, error) {
+	uploadID, err := client.CreateMultipartUpload(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload: %v", err)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate session ID: %v", err)
+	}
+
+	pipeR, pipeW := io.Pipe()
+
+	ageWriter, err := age.Encrypt(pipeW, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up age encryption stream: %v", err)
+	}
+
+	s := &Session{
+		ID:           id,
+		Name:         name,
+		Owner:        owner,
+		client:       client,
+		uploadID:     uploadID,
+		expectedSize: size,
+		partSize:     m.partSize(),
+		ageWriter:    ageWriter,
+		pipeW:        pipeW,
+		uploadErr:    make(chan error, 1),
+		ttl:          m.ttl(),
+		expiresAt:    time.Now().Add(m.ttl()),
+	}
+
+	go s.uploadParts(pipeR)
+
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+
+	return s, nil
+}
+
+func (m *Manager) partSize() int64 {
+	if m.PartSize > 0 {
+		return m.PartSize
+	}
+	return defaultPartSize
+}
+
+func (m *Manager) ttl() time.Duration {
+	if m.TTL > 0 {
+		return m.TTL
+	}
+	return time.Hour
+}
+
+/*
+Get returns the session with the given ID, if it is still open
+*/
+func (m *Manager) Get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+/*
+Remove drops a session from the manager, e.g. after it has been
+finalized or aborted
+*/
+func (m *Manager) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
+
+/*
+Sweep removes every session that has been idle for longer than its TTL,
+aborting its multipart upload so the backend does not keep orphaned
+parts around indefinitely
+*/
+func (m *Manager) Sweep(ctx context.Context) {
+	m.mu.Lock()
+	var expired []*Session
+	for id, s := range m.sessions {
+		s.mu.Lock()
+		isExpired := time.Now().After(s.expiresAt)
+		s.mu.Unlock()
+		if isExpired {
+			expired = append(expired, s)
+			delete(m.sessions, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, s := range expired {
+		_ = s.Abort(ctx)
+	}
+}
+
+/*
+uploadParts reads the age-encrypted stream in PartSize chunks and
+uploads each as one backend part, running for the lifetime of the
+session in its own goroutine. On any error it closes r with that
+error, rather than just returning, so that a WriteChunk blocked
+writing into the other end of the pipe fails immediately instead of
+hanging until the backend part upload (or the read itself) times out
+*/
+func (s *Session) uploadParts(r *io.PipeReader) {
+	buf := make([]byte, s.partSize)
+	partNumber := 1
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			uploadErr := s.client.UploadPart(context.Background(), s.uploadID, partNumber, bytes.NewReader(buf[:n]), int64(n))
+			if uploadErr != nil {
+				wrapped := fmt.Errorf("failed to upload part %d: %v", partNumber, uploadErr)
+				r.CloseWithError(wrapped)
+				s.uploadErr <- wrapped
+				return
+			}
+			partNumber++
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.uploadErr <- nil
+			return
+		}
+		if err != nil {
+			r.CloseWithError(err)
+			s.uploadErr <- fmt.Errorf("failed to read encrypted stream: %v", err)
+			return
+		}
+	}
+}
+
+/*
+WriteChunk writes a single chunk of plaintext at offset, which must
+equal the number of bytes already received, mirroring tus.io's
+Content-Range semantics. It always returns the session's new total
+offset, even when it also returns an error, so a caller can tell the
+client where to resume from.
+
+The session lock is held for the entire write, not just the offset
+check: releasing it beforehand would let two concurrent chunks at the
+same offset both pass validation and interleave into the single age
+stream, corrupting the object. Holding it also means a chunk that
+fails partway through still advances received by however many bytes it
+did write, so a client that retries at the old offset is rejected
+instead of duplicating those bytes into the stream
+*/
+func (s *Session) WriteChunk(offset int64, r io.Reader) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return s.received, fmt.Errorf("session is already finalized or aborted")
+	}
+	if offset != s.received {
+		return s.received, fmt.Errorf("chunk offset %d does not match session offset %d", offset, s.received)
+	}
+
+	n, err := io.Copy(s.ageWriter, r)
+	s.received += n
+	s.expiresAt = time.Now().Add(s.ttl)
+	if err != nil {
+		return s.received, fmt.Errorf("failed to write chunk: %v", err)
+	}
+
+	return s.received, nil
+}
+
+/*
+Offset returns how many plaintext bytes have been received so far
+*/
+func (s *Session) Offset() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+/*
+Complete reports whether every expected byte has been received
+*/
+func (s *Session) Complete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received >= s.expectedSize
+}
+
+/*
+Finalize closes the encryption stream and waits for the final part to
+be uploaded, then commits the multipart upload
+*/
+func (s *Session) Finalize(ctx context.Context) error {
+	s.mu.Lock()
+	s.done = true
+	s.mu.Unlock()
+
+	if err := s.ageWriter.Close(); err != nil {
+		return fmt.Errorf("failed to close encryption stream: %v", err)
+	}
+	if err := s.pipeW.Close(); err != nil {
+		return fmt.Errorf("failed to close upload pipe: %v", err)
+	}
+
+	if err := <-s.uploadErr; err != nil {
+		return err
+	}
+
+	if err := s.client.CompleteMultipartUpload(ctx, s.uploadID); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %v", err)
+	}
+
+	return nil
+}
+
+/*
+Abort discards the session and any parts already uploaded to the
+backend
+*/
+func (s *Session) Abort(ctx context.Context) error {
+	s.mu.Lock()
+	s.done = true
+	s.mu.Unlock()
+
+	s.ageWriter.Close()
+	s.pipeW.CloseWithError(fmt.Errorf("session aborted"))
+	<-s.uploadErr
+
+	return s.client.AbortMultipartUpload(ctx, s.uploadID)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/daknob/eldim/config"
+	"github.com/daknob/eldim/internal/backend"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -20,6 +21,10 @@ import (
 var (
 	conf    config.Config
 	clients []config.ClientConfig
+
+	/* replicator fans uploads out to the configured backends according
+	to the replication policy */
+	replicator *backend.Replicator
 )
 
 const (
@@ -66,6 +71,14 @@ func main() {
 
 	logrus.Printf("Configuration file loaded.")
 
+	/* Resolve any secret references (age:, file:, env:, exec:) before
+	validating, so Validate only ever sees plaintext */
+	logrus.Printf("Resolving secret references...")
+	err = conf.ResolveSecrets(context.Background())
+	if err != nil {
+		logrus.Fatalf("Could not resolve secret references: %v", err)
+	}
+
 	/* Validate configuration by appropriate function call */
 	logrus.Printf("Validating parameters...")
 	err = conf.Validate()
@@ -83,6 +96,12 @@ func main() {
 	if err != nil {
 		logrus.Fatalf("Could not parse clients YML file: %v", err)
 	}
+	for i := range clients {
+		err = clients[i].ResolveSecrets(context.Background())
+		if err != nil {
+			logrus.Fatalf("Could not resolve secret references for client '%s': %v", clients[i].Name(), err)
+		}
+	}
 
 	/* Register Prometheus Metrics */
 	registerPromMetrics()
@@ -90,6 +109,25 @@ func main() {
 	/* Update configuration-based Metrics */
 	updateConfMetrics()
 
+	/* Build the replicator that fans uploads out to the configured
+	backends according to the replication policy, and start its
+	reconciliation queue, if one is configured */
+	logrus.Printf("Configuring backend replication...")
+	replicator, err = conf.Replicator()
+	if err != nil {
+		logrus.Fatalf("Failed to configure backend replication: %v", err)
+	}
+	replicator.Recorder = recorder
+	if replicator.Reconciler != nil {
+		go replicator.Reconciler.Run(context.Background())
+	}
+
+	/* Configure the audit trail */
+	configureAudit()
+
+	/* Periodically remove upload sessions idle longer than their TTL */
+	startSessionSweeper()
+
 	/* Various web server configurations */
 	logrus.Printf("Configuring the HTTP Server...")
 
@@ -97,6 +135,8 @@ func main() {
 	router := httprouter.New()
 	router.GET("/", index)
 	router.POST("/api/v1/file/upload/", v1fileUpload)
+	router.POST("/api/v1/file/upload/session", v1fileUploadSessionCreate)
+	router.PATCH("/api/v1/file/upload/session/:id", v1fileUploadSessionPatch)
 
 	/* Only enable Prometheus metrics if configured */
 	if conf.PrometheusEnabled {
@@ -104,7 +144,7 @@ func main() {
 			"/metrics",
 			requestBasicAuth(
 				conf.PrometheusAuthUser,
-				conf.PrometheusAuthPass,
+				conf.PrometheusAuthPass.String(),
 				"Prometheus Metrics",
 				*promMetricsAuth,
 				httpHandlerToHTTPRouterHandler(
@@ -114,14 +154,25 @@ func main() {
 		)
 	}
 
+	/* Only expose the audit verification endpoint if auditing is
+	enabled */
+	if conf.Audit.Enabled {
+		router.GET(
+			"/api/v1/audit/verify",
+			requestBasicAuth(
+				conf.Audit.VerifyAuthUser,
+				conf.Audit.VerifyAuthPass.String(),
+				"Audit Verification",
+				*auditVerifyAuth,
+				v1auditVerify,
+			),
+		)
+	}
+
 	/* Configure TLS */
-	tlsConfig := &tls.Config{
-		PreferServerCipherSuites: true,
-		CurvePreferences: []tls.CurveID{
-			tls.CurveP256,
-			tls.X25519,
-		},
-		MinVersion: tls.VersionTLS12,
+	tlsConfig, err := conf.BuildTLSConfig(conf.TLSChainPath, conf.TLSKeyPath)
+	if err != nil {
+		logrus.Fatalf("Failed to configure TLS: %v", err)
 	}
 
 	/* Configure HTTP */
@@ -139,10 +190,9 @@ func main() {
 	/* Start serving TLS */
 	logrus.Printf("Serving on :%d ...", conf.ListenPort)
 
-	err = server.ListenAndServeTLS(
-		conf.TLSChainPath,
-		conf.TLSKeyPath,
-	)
+	/* Certificates are already loaded into tlsConfig by BuildTLSConfig,
+	whether from TLSChainPath/TLSKeyPath or from ACME */
+	err = server.ListenAndServeTLS("", "")
 	if err != nil {
 		logrus.Fatalf("Failed to start HTTP Server: %v", err)
 	}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/daknob/eldim/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	/* promMetricsAuth counts authentication attempts against the
+	/metrics endpoint itself, successful or not */
+	promMetricsAuth = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "eldim",
+		Name:      "metrics_auth_total",
+		Help:      "Number of authentication attempts against /metrics",
+	}, []string{"outcome"})
+
+	/* confBackends reports how many backends of each type are
+	currently configured */
+	confBackends = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "eldim",
+		Name:      "config_backends",
+		Help:      "Number of configured backends, by type",
+	}, []string{"backend_type"})
+
+	/* confClients reports how many clients are currently configured */
+	confClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "eldim",
+		Name:      "config_clients",
+		Help:      "Number of configured clients",
+	})
+
+	/* auditVerifyAuth counts authentication attempts against the
+	/api/v1/audit/verify endpoint itself, successful or not */
+	auditVerifyAuth = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "eldim",
+		Name:      "audit_verify_auth_total",
+		Help:      "Number of authentication attempts against /api/v1/audit/verify",
+	}, []string{"outcome"})
+
+	/* recorder is eldim's shared per-backend upload metrics recorder */
+	recorder metrics.Recorder
+)
+
+/*
+registerPromMetrics registers every Prometheus collector eldim exposes,
+including the per-backend latency/size histograms in internal/metrics
+*/
+func registerPromMetrics() {
+	prometheus.MustRegister(promMetricsAuth)
+	prometheus.MustRegister(confBackends)
+	prometheus.MustRegister(confClients)
+	prometheus.MustRegister(auditVerifyAuth)
+
+	recorder = metrics.NewRecorder(prometheus.DefaultRegisterer)
+}
+
+/*
+updateConfMetrics sets the configuration-derived gauges from the
+currently loaded conf and clients
+*/
+func updateConfMetrics() {
+	confBackends.WithLabelValues("swift").Set(float64(len(conf.SwiftBackends)))
+	confBackends.WithLabelValues("gcs").Set(float64(len(conf.GCSBackends)))
+	confBackends.WithLabelValues("s3").Set(float64(len(conf.S3Backends)))
+
+	confClients.Set(float64(len(clients)))
+}
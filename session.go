@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/daknob/eldim/internal/audit"
+	"github.com/daknob/eldim/internal/session"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+sessions tracks every open resumable upload session
+*/
+var sessions = session.NewManager()
+
+/*
+startSessionSweeper periodically removes upload sessions that have
+been idle for longer than their TTL, aborting their backend multipart
+upload so it doesn't linger forever
+*/
+func startSessionSweeper() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			sessions.Sweep(context.Background())
+		}
+	}()
+}
+
+/*
+uploadSessionResponse is returned when a new session is opened
+*/
+type uploadSessionResponse struct {
+	ID string `json:"id"`
+}
+
+/*
+recordAuditEvent records e to the shared audit trail, if auditing is
+enabled. It logs, rather than fails the request, if recording itself
+fails, since a request that already succeeded or was already denied
+shouldn't be undone by an audit sink error
+*/
+func recordAuditEvent(e audit.Event) {
+	if auditChain == nil {
+		return
+	}
+	if err := auditChain.Record(e); err != nil {
+		logrus.Errorf("Failed to record audit event: %v", err)
+	}
+}
+
+/*
+v1fileUploadSessionCreate opens a new resumable upload session,
+modeled on tus.io's creation extension: the client declares the total
+upload size via the Upload-Length header and the destination object
+name via the X-Eldim-Object-Name header, and receives back a session
+ID to PATCH chunks to
+*/
+func v1fileUploadSessionCreate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	client, err := authenticateClient(r)
+	if err != nil {
+		logrus.Warnf("Rejected upload session request from %s: %v", remoteIP(r), err)
+		recordAuditEvent(audit.Event{
+			Timestamp: time.Now(),
+			SourceIP:  remoteIP(r),
+			Success:   false,
+			Reason:    err.Error(),
+		})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sizeHeader := r.Header.Get("Upload-Length")
+	size, err := strconv.ParseInt(sizeHeader, 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "Upload-Length header must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	name := r.Header.Get("X-Eldim-Object-Name")
+	if name == "" {
+		http.Error(w, "X-Eldim-Object-Name header is required", http.StatusBadRequest)
+		return
+	}
+
+	mpClient, err := replicator.PrimaryMultipartClient()
+	if err != nil {
+		http.Error(w, "No configured backend supports resumable uploads", http.StatusNotImplemented)
+		return
+	}
+
+	recipients, err := conf.Recipients()
+	if err != nil {
+		logrus.Errorf("Failed to load age recipients for session upload: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	s, err := sessions.Create(r.Context(), mpClient, client.Name(), name, size, recipients)
+	if err != nil {
+		logrus.Errorf("Failed to create upload session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	recordAuditEvent(audit.Event{
+		Timestamp: time.Now(),
+		Client:    client.Name(),
+		SourceIP:  remoteIP(r),
+		Object:    name,
+		Size:      size,
+		Backends:  []string{mpClient.Name()},
+		Success:   true,
+	})
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/file/upload/session/%s", s.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(uploadSessionResponse{ID: s.ID})
+}
+
+/*
+v1fileUploadSessionPatch appends one chunk to an open session. The
+chunk's position is given by the Upload-Offset header, which must
+match the number of bytes the session has received so far. Only the
+client that created the session may PATCH chunks to it
+*/
+func v1fileUploadSessionPatch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	client, err := authenticateClient(r)
+	if err != nil {
+		logrus.Warnf("Rejected upload session chunk from %s: %v", remoteIP(r), err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s, ok := sessions.Get(ps.ByName("id"))
+	if !ok {
+		http.Error(w, "No such upload session", http.StatusNotFound)
+		return
+	}
+	if s.Owner != client.Name() {
+		logrus.Warnf("Client '%s' tried to PATCH session %s owned by '%s'", client.Name(), s.ID, s.Owner)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	offsetHeader := r.Header.Get("Upload-Offset")
+	offset, err := strconv.ParseInt(offsetHeader, 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset header must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	newOffset, err := s.WriteChunk(offset, r.Body)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if err != nil {
+		logrus.Warnf("Upload session %s rejected a chunk: %v", s.ID, err)
+		http.Error(w, fmt.Sprintf("Conflict: %v", err), http.StatusConflict)
+		return
+	}
+
+	if s.Complete() {
+		err = s.Finalize(r.Context())
+		sessions.Remove(s.ID)
+		recordAuditEvent(audit.Event{
+			Timestamp: time.Now(),
+			Client:    client.Name(),
+			SourceIP:  remoteIP(r),
+			Object:    s.Name,
+			Size:      newOffset,
+			Success:   err == nil,
+			Reason:    errString(err),
+		})
+		if err != nil {
+			logrus.Errorf("Failed to finalize upload session %s: %v", s.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+errString returns err's message, or "" if err is nil, for audit events
+where Reason is only meaningful on failure
+*/
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}